@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+	"github.com/kjk/minio"
+)
+
+// how many previous builds we generate bsdiff patches from, so a client a
+// few updates behind still gets a small delta instead of a full download
+const nPrevBuildsForPatches = 3
+
+// patchArches and patchSuffixes enumerate the artifacts we diff; they
+// mirror the naming used by getDownloadUrlsForPrefix
+var (
+	patchArches   = []string{"", "-64"}
+	patchSuffixes = []string{"-install.exe", ".exe", ".zip"}
+)
+
+// patchSuffixTags maps a patchSuffix to the tag appended to its patch file
+// name. Can't derive this with filepath.Ext(suffix): both ".exe" and
+// ".zip" are their own whole extension, so stripping it leaves "" for
+// both and the portable-exe and portable-zip patches collide on the same
+// object key
+var patchSuffixTags = map[string]string{
+	"-install.exe": "-install",
+	".exe":         "",
+	".zip":         "-zip",
+}
+
+type patchInfo struct {
+	fromVer string
+	url     string
+	sha256  string
+	size    int64
+}
+
+func patchArtifactBase(buildType, ver string) string {
+	if buildType == buildTypePreRel {
+		return "SumatraPDF-prerel"
+	}
+	return "SumatraPDF-" + ver
+}
+
+// compareVersions orders both dotted release versions ("3.1.2") and plain
+// pre-release build numbers ("14283") the same way
+func compareVersions(a, b string) int {
+	pa := strings.Split(a, ".")
+	pb := strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			return na - nb
+		}
+	}
+	return 0
+}
+
+// prevVersionsForPatches returns up to nPrevBuildsForPatches versions of
+// buildType older than newVer that are currently in storage, most recent
+// first
+func prevVersionsForPatches(mc *minio.Client, buildType string, newVer string) []string {
+	remoteDir := "software/sumatrapdf/" + buildType + "/"
+	seen := map[string]bool{}
+	var vers []string
+	for f := range mc.ListObjects(remoteDir) {
+		parts := strings.Split(f.Key, "/")
+		if len(parts) < 4 {
+			continue
+		}
+		v := parts[3]
+		if compareVersions(v, newVer) >= 0 || seen[v] {
+			continue
+		}
+		seen[v] = true
+		vers = append(vers, v)
+	}
+	sort.Slice(vers, func(i, j int) bool {
+		return compareVersions(vers[i], vers[j]) > 0
+	})
+	if len(vers) > nPrevBuildsForPatches {
+		vers = vers[:nPrevBuildsForPatches]
+	}
+	return vers
+}
+
+// patchFromVersion extracts the "from" version out of a patch file name
+// like "SumatraPDF-14280-to-14283-64.bsdiff" or
+// "SumatraPDF-3.1-to-3.2-64-install.bsdiff". Returns ok=false for keys
+// that aren't bsdiff patches
+func patchFromVersion(key string) (string, bool) {
+	name := path.Base(key)
+	if !strings.HasSuffix(name, ".bsdiff") {
+		return "", false
+	}
+	name = strings.TrimPrefix(name, "SumatraPDF-")
+	name = strings.TrimSuffix(name, ".bsdiff")
+	parts := strings.SplitN(name, "-to-", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// deltaUpload computes bsdiff patches from each of the last
+// nPrevBuildsForPatches builds of buildType to the build we're currently
+// publishing (read from dirLocal) and uploads them under
+// software/sumatrapdf/<buildType>/<newVer>/patches/. Skips any
+// (oldVer, arch, suffix) combination whose old artifact isn't available,
+// e.g. because it's already been pruned by minioDeleteOldBuildsPrefix
+func deltaUpload(mc *minio.Client, buildType string, dirLocal string) []patchInfo {
+	newVer := getVerForBuildType(buildType)
+	prevVers := prevVersionsForPatches(mc, buildType, newVer)
+	if len(prevVers) == 0 {
+		logf(ctx(), "deltaUpload: no previous '%s' builds to diff against\n", buildType)
+		return nil
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var patches []patchInfo
+	for _, oldVer := range prevVers {
+		for _, arch := range patchArches {
+			for _, suffix := range patchSuffixes {
+				oldVer, arch, suffix := oldVer, arch, suffix
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p := deltaUploadOneMust(mc, buildType, dirLocal, oldVer, newVer, arch, suffix)
+					if p == nil {
+						return
+					}
+					mu.Lock()
+					patches = append(patches, *p)
+					mu.Unlock()
+				}()
+			}
+		}
+	}
+	wg.Wait()
+	return patches
+}
+
+// deltaUploadOneMust computes and uploads a single bsdiff patch. Returns
+// nil (not an error) if the new build doesn't have this artifact variant
+// or the old one isn't available in storage to diff against
+func deltaUploadOneMust(mc *minio.Client, buildType string, dirLocal string, oldVer string, newVer string, arch string, suffix string) *patchInfo {
+	newName := patchArtifactBase(buildType, newVer) + arch + suffix
+	newData, err := os.ReadFile(filepath.Join(dirLocal, newName))
+	if err != nil {
+		return nil
+	}
+
+	oldKey := path.Join("software/sumatrapdf/"+buildType+"/"+oldVer, patchArtifactBase(buildType, oldVer)+arch+suffix)
+	if !mc.Exists(oldKey) {
+		return nil
+	}
+	oldData := downloadMust(mc.URLForPath(oldKey))
+
+	patch, err := bsdiff.Bytes(oldData, newData)
+	must(err)
+
+	patchName := fmt.Sprintf("SumatraPDF-%s-to-%s%s%s.bsdiff", oldVer, newVer, arch, patchSuffixTags[suffix])
+	patchKey := path.Join(getRemoteDir(buildType), "patches", patchName)
+	_, err = mc.UploadData(patchKey, patch, true)
+	must(err)
+
+	sum := sha256.Sum256(patch)
+	logf(ctx(), "Uploaded patch '%s' (%d -> %d bytes)\n", patchKey, len(oldData), len(patch))
+	return &patchInfo{
+		fromVer: oldVer,
+		// same mirror-agnostic https://www.sumatrapdfreader.org/dl/... prefix
+		// as every other URL in the manifest, not mc.URLForPath's raw
+		// per-mirror storage URL: otherwise each mirror's Patches: block
+		// (and therefore its signed manifest) differs once a patch exists,
+		// and verifyManifestsMust's cross-mirror signature check fails
+		url:    websiteDlPrefix(buildType, newVer) + "patches/" + patchName,
+		sha256: hex.EncodeToString(sum[:]),
+		size:   int64(len(patch)),
+	}
+}
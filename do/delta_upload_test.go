@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"3.1.2", "3.1.2", 0},
+		{"3.1.2", "3.1.10", -1},
+		{"3.2", "3.1.2", 1},
+		{"14283", "14280", 1},
+		{"14280", "14283", -1},
+		{"14283", "14283", 0},
+	}
+	for _, tt := range tests {
+		got := compareVersions(tt.a, tt.b)
+		if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) || (got == 0) != (tt.want == 0) {
+			t.Errorf("compareVersions(%q, %q) = %d, want sign %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPatchFromVersion(t *testing.T) {
+	tests := []struct {
+		key      string
+		wantFrom string
+		wantOk   bool
+	}{
+		{"software/sumatrapdf/prerel/14283/patches/SumatraPDF-14280-to-14283-64.bsdiff", "14280", true},
+		{"software/sumatrapdf/rel/3.2/patches/SumatraPDF-3.1-to-3.2-64-install.bsdiff", "3.1", true},
+		{"software/sumatrapdf/rel/3.2/SumatraPDF-3.2.exe", "", false},
+	}
+	for _, tt := range tests {
+		gotFrom, gotOk := patchFromVersion(tt.key)
+		if gotOk != tt.wantOk || gotFrom != tt.wantFrom {
+			t.Errorf("patchFromVersion(%q) = (%q, %v), want (%q, %v)", tt.key, gotFrom, gotOk, tt.wantFrom, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/kjk/minio"
+)
+
+// flagRepairMirrors is set from the --repair command line flag; when true,
+// verifyMirrorsConsistent fixes divergent mirrors instead of panicking
+var flagRepairMirrors bool
+
+type mirror struct {
+	name string
+	mc   *minio.Client
+}
+
+func allMirrors() []mirror {
+	return []mirror{
+		{"s3", newMinioS3Client()},
+		{"backblaze", newMinioBackblazeClient()},
+		{"spaces", newMinioSpacesClient()},
+	}
+}
+
+type mirrorObjectInfo struct {
+	size int64
+	etag string
+}
+
+func listMirrorObjects(mc *minio.Client, remoteDir string) map[string]mirrorObjectInfo {
+	res := map[string]mirrorObjectInfo{}
+	for f := range mc.ListObjects(remoteDir) {
+		res[f.Key] = mirrorObjectInfo{size: f.Size, etag: f.ETag}
+	}
+	return res
+}
+
+// verifyMirrorsConsistent lists every object under getRemoteDir(buildType)
+// on all three mirrors and asserts they agree: same set of keys, same
+// size, same content. ETags aren't comparable across providers (e.g. B2
+// multipart vs S3 single-part uploads hash differently even for identical
+// bytes), so a same-size mismatch or disagreeing ETags falls back to a
+// full download-and-sha256 compare. Panics on any disagreement unless
+// --repair was passed, in which case it re-uploads from whatever's still
+// in the local out/final-* staging directory
+func verifyMirrorsConsistent(buildType string) {
+	remoteDir := getRemoteDir(buildType)
+	mirrors := allMirrors()
+
+	byMirror := map[string]map[string]mirrorObjectInfo{}
+	allKeys := map[string]bool{}
+	for _, m := range mirrors {
+		objs := listMirrorObjects(m.mc, remoteDir)
+		byMirror[m.name] = objs
+		for k := range objs {
+			allKeys[k] = true
+		}
+	}
+
+	var keys []string
+	for k := range allKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var missingLines []string
+	var mismatchLines []string
+	var badKeys []string
+
+	for _, key := range keys {
+		present := map[string]mirrorObjectInfo{}
+		for _, m := range mirrors {
+			if info, ok := byMirror[m.name][key]; ok {
+				present[m.name] = info
+			} else {
+				missingLines = append(missingLines, fmt.Sprintf("%s: missing from %s", key, m.name))
+				badKeys = append(badKeys, key)
+			}
+		}
+		if len(present) != len(mirrors) {
+			continue
+		}
+
+		sizesAgree, etagsAgree := true, true
+		var firstSize int64
+		var firstEtag string
+		for i, m := range mirrors {
+			info := present[m.name]
+			if i == 0 {
+				firstSize, firstEtag = info.size, info.etag
+				continue
+			}
+			if info.size != firstSize {
+				sizesAgree = false
+			}
+			if info.etag != firstEtag {
+				etagsAgree = false
+			}
+		}
+		if !sizesAgree {
+			mismatchLines = append(mismatchLines, fmt.Sprintf("%s: size mismatch (%v)", key, present))
+			badKeys = append(badKeys, key)
+			continue
+		}
+		if etagsAgree {
+			// identical size and identical ETag: good enough, skip the
+			// expensive download-and-hash fallback
+			continue
+		}
+		if !contentMatchesAcrossMirrors(mirrors, key) {
+			mismatchLines = append(mismatchLines, fmt.Sprintf("%s: sha256 mismatch", key))
+			badKeys = append(badKeys, key)
+		}
+	}
+
+	if len(missingLines) == 0 && len(mismatchLines) == 0 {
+		logf(ctx(), "verifyMirrorsConsistent: %d objects under '%s' agree across all mirrors\n", len(keys), remoteDir)
+		return
+	}
+
+	logf(ctx(), "Mirror inconsistencies under '%s':\n", remoteDir)
+	for _, l := range missingLines {
+		logf(ctx(), "  %s\n", l)
+	}
+	for _, l := range mismatchLines {
+		logf(ctx(), "  %s\n", l)
+	}
+
+	if !flagRepairMirrors {
+		panicIf(true, "mirrors diverged under '%s' (%d bad keys); re-run with --repair to fix", remoteDir, len(badKeys))
+	}
+
+	repairMirrorsMust(buildType, mirrors, badKeys)
+}
+
+func contentMatchesAcrossMirrors(mirrors []mirror, key string) bool {
+	var firstHash string
+	for i, m := range mirrors {
+		d := downloadMust(m.mc.URLForPath(key))
+		sum := sha256.Sum256(d)
+		h := hex.EncodeToString(sum[:])
+		if i == 0 {
+			firstHash = h
+			continue
+		}
+		if h != firstHash {
+			return false
+		}
+	}
+	return true
+}
+
+// repairMirrorsMust re-uploads every key in badKeys to all three mirrors
+// from the local out/final-* directory the current build left behind. A
+// key whose local file is already gone can't be repaired this way and is
+// logged instead of fixed; re-running the build is the only recovery then
+func repairMirrorsMust(buildType string, mirrors []mirror, badKeys []string) {
+	dirLocal := getFinalDirForBuildType(buildType)
+	seen := map[string]bool{}
+	for _, key := range badKeys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		name := filepath.Base(key)
+		localPath := filepath.Join(dirLocal, name)
+		data, err := os.ReadFile(localPath)
+		if err != nil {
+			logf(ctx(), "repair: can't fix '%s', local file '%s' is gone: %s\n", key, localPath, err)
+			continue
+		}
+		for _, m := range mirrors {
+			_, err := m.mc.UploadData(key, data, true)
+			must(err)
+			logf(ctx(), "repair: re-uploaded '%s' to %s\n", key, m.name)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flagReproducible gates packagePortable. Off by default until CI has run
+// the "hash two consecutive builds, compare" check this depends on; flip
+// the default once that's proven stable
+var flagReproducible bool
+
+// portableZipCompressionLevel is fixed (rather than left at whatever the
+// flate default is) so the same inputs always produce the same zip bytes
+const portableZipCompressionLevel = flate.BestCompression
+
+// hashWriter wraps an io.Writer and accumulates a sha256 of everything
+// written through it, so the zip's hash can be computed in the same pass
+// that writes it to disk instead of a second read-back
+type hashWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func newHashWriter(w io.Writer) *hashWriter {
+	return &hashWriter{w: w, h: sha256.New()}
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	hw.h.Write(p)
+	return hw.w.Write(p)
+}
+
+func (hw *hashWriter) Sum() string {
+	return hex.EncodeToString(hw.h.Sum(nil))
+}
+
+// portableArtifact is what packagePortable computed for one arch, kept
+// around so the caller building the update manifest can reuse the hash
+// instead of re-reading the zip off disk a second time
+type portableArtifact struct {
+	sha256Hex string
+	sizeBytes int64
+}
+
+// packageAllPortableMust packages the 32-bit and 64-bit portable zips for
+// buildType once, before the mirror uploads fan out, so three concurrent
+// goroutines (one per mirror) don't all try to zip the same exe into the
+// same zipPath at the same time
+func packageAllPortableMust(buildType string) map[string]portableArtifact {
+	res := map[string]portableArtifact{}
+	for _, arch := range []string{"32", "64"} {
+		_, sha256Hex, sizeBytes := packagePortable(buildType, arch)
+		res[arch] = portableArtifact{sha256Hex: sha256Hex, sizeBytes: sizeBytes}
+	}
+	return res
+}
+
+func getGitCommitTimeMust() time.Time {
+	out, err := exec.Command("git", "log", "-1", "--format=%ct").Output()
+	must(err)
+	sec, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	must(err)
+	return time.Unix(sec, 0).UTC()
+}
+
+// packagePortable builds the portable SumatraPDF-<ver>[-64].zip for
+// buildType/arch directly from the staged exe, instead of trusting
+// whatever zipped it in out/final-*. Forcing the mtime to the commit time
+// and the compression level to a constant means the same build produces
+// byte-identical zips on any machine, so two mirrors can be compared by
+// sha256 instead of "trust the upload succeeded"
+func packagePortable(buildType string, arch string) (zipPath string, sha256Hex string, sizeBytes int64) {
+	dirLocal := getFinalDirForBuildType(buildType)
+	ver := getVerForBuildType(buildType)
+	archSuffix := ""
+	if arch == "64" {
+		archSuffix = "-64"
+	}
+	base := patchArtifactBase(buildType, ver)
+	exeName := base + archSuffix + ".exe"
+	exePath := filepath.Join(dirLocal, exeName)
+	panicIf(!fileExists(exePath), "packagePortable: '%s' not found", exePath)
+
+	zipPath = filepath.Join(dirLocal, base+archSuffix+".zip")
+	mtime := getGitCommitTimeMust()
+
+	f, err := os.Create(zipPath)
+	must(err)
+	defer f.Close()
+
+	hw := newHashWriter(f)
+	zw := zip.NewWriter(hw)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, portableZipCompressionLevel)
+	})
+
+	must(addFileToZipDeterministic(zw, exePath, exeName, mtime))
+	must(zw.Close())
+
+	info, err := os.Stat(zipPath)
+	must(err)
+	return zipPath, hw.Sum(), info.Size()
+}
+
+// addFileToZipDeterministic writes path into zw under name, with its
+// modified time forced to mtime so the resulting zip entry is identical
+// across build machines regardless of local file timestamps
+func addFileToZipDeterministic(zw *zip.Writer, path string, name string, mtime time.Time) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fh := &zip.FileHeader{
+		Name:     filepath.ToSlash(name),
+		Method:   zip.Deflate,
+		Modified: mtime,
+	}
+	w, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kjk/minio"
+)
+
+// website/rollout-state.json is the local copy of whatever rollout.json
+// we last published, so `rollout bump`/`rollout rollback` can be re-run
+// from a fresh checkout without re-deriving the current state from S3
+const rolloutStatePath = "website/rollout-state.json"
+
+// how long a rollout must sit at a given percentage before it's allowed to
+// bump again, so a bad build has time to surface crash reports before
+// reaching more users
+const defaultMinDwellHours = 4
+
+// RolloutPolicy is the gating policy for a staged rollout of a rel build.
+// Clients decide eligibility themselves via crc32(installID+salt)%100 <
+// percent; this struct is just what the server atomically publishes
+type RolloutPolicy struct {
+	StableVer     string `json:"stableVer"`
+	CandidateVer  string `json:"candidateVer"`
+	Percent       int    `json:"percent"`
+	Salt          string `json:"salt"`
+	StartedAt     string `json:"startedAt"`
+	MinDwellHours int    `json:"minDwellHours"`
+}
+
+// loadRolloutStateMust returns nil if no rollout is in progress
+func loadRolloutStateMust() *RolloutPolicy {
+	d, err := os.ReadFile(rolloutStatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	must(err)
+	var rs RolloutPolicy
+	must(json.Unmarshal(d, &rs))
+	return &rs
+}
+
+func saveRolloutStateMust(rs *RolloutPolicy) {
+	d, err := json.MarshalIndent(rs, "", "  ")
+	must(err)
+	writeFileMust(rolloutStatePath, d)
+}
+
+func genRolloutSalt() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	must(err)
+	return hex.EncodeToString(b)
+}
+
+func nowRFC3339() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func hoursSince(t string) float64 {
+	start, err := time.Parse(time.RFC3339, t)
+	must(err)
+	return time.Since(start).Hours()
+}
+
+func genStableManifestMust(ver string) string {
+	validateVer(ver)
+	return fmt.Sprintf("[SumatraPDF]\nLatest %s\n", ver)
+}
+
+// uploadRolloutManifestsMust publishes update-check-rel.txt (the current
+// stable pointer, unchanged by a rollout), update-check-rel-next.txt (the
+// candidate) and rollout.json (the gating policy) to all three mirrors,
+// so a client never observes rollout.json pointing at a candidate that
+// doesn't have a manifest yet. It also writes website/update-check-rel.txt
+// and website/update-check-rel-next.txt, the files actually served at
+// https://www.sumatrapdfreader.org/update-check-rel{,-next}.txt that 3.2+
+// clients check against, same as updateAutoUpdateVer does outside of a
+// rollout: those still need to be checked in and the website deployed
+func uploadRolloutManifestsMust(rs *RolloutPolicy) {
+	stableS := genStableManifestMust(rs.StableVer)
+	nextS := genStableManifestMust(rs.CandidateVer)
+	rolloutJSON, err := json.MarshalIndent(rs, "", "  ")
+	must(err)
+
+	uploadTo := func(mc *minio.Client) {
+		signAndUploadManifestMust(mc, "sumatrapdf/update-check-rel.txt", stableS)
+		signAndUploadManifestMust(mc, "sumatrapdf/update-check-rel-next.txt", nextS)
+		_, err := mc.UploadData("sumatrapdf/rollout.json", rolloutJSON, true)
+		must(err)
+	}
+	uploadTo(newMinioS3Client())
+	uploadTo(newMinioBackblazeClient())
+	uploadTo(newMinioSpacesClient())
+
+	key := loadUpdateSignKeyMust()
+	stablePath := filepath.Join("website", "update-check-rel.txt")
+	writeFileMust(stablePath, []byte(addSignatureLine(key, stableS)))
+	nextPath := filepath.Join("website", "update-check-rel-next.txt")
+	writeFileMust(nextPath, []byte(addSignatureLine(key, nextS)))
+
+	fmt.Printf("Don't forget to checkin '%s' and '%s' and deploy website\n", stablePath, nextPath)
+}
+
+// startStagedRollout begins advertising newVer to percent% of clients,
+// keeping whatever's currently stable (from a prior rollout, if any)
+// untouched for everyone else
+func startStagedRollout(newVer string, percent int) {
+	panicIf(percent <= 0 || percent >= 100, "rollout percent must be between 1 and 99, got %d", percent)
+	existing := loadRolloutStateMust()
+	stableVer := ""
+	if existing != nil {
+		stableVer = existing.StableVer
+	} else {
+		// no rollout yet: stable is whatever updateAutoUpdateVer last wrote
+		d, err := os.ReadFile(filepath.Join("website", "update-check-rel.txt"))
+		must(err)
+		for _, line := range strings.Split(string(d), "\n") {
+			if strings.HasPrefix(line, "Latest ") {
+				stableVer = strings.TrimSpace(strings.TrimPrefix(line, "Latest "))
+			}
+		}
+		panicIf(stableVer == "", "no 'Latest' line in website/update-check-rel.txt")
+	}
+
+	rs := &RolloutPolicy{
+		StableVer:     stableVer,
+		CandidateVer:  newVer,
+		Percent:       percent,
+		Salt:          genRolloutSalt(),
+		StartedAt:     nowRFC3339(),
+		MinDwellHours: defaultMinDwellHours,
+	}
+	saveRolloutStateMust(rs)
+	uploadRolloutManifestsMust(rs)
+	fmt.Printf("Started rollout of %s at %d%%\n", newVer, percent)
+}
+
+// rolloutBumpMust advances the in-progress rollout to percent, refusing
+// to do so before minDwellHours has elapsed since the last change.
+// Reaching 100 promotes the candidate to stable and ends the rollout
+func rolloutBumpMust(percent int) {
+	rs := loadRolloutStateMust()
+	panicIf(rs == nil, "no rollout in progress; start one with updateAutoUpdateVer's --rollout flag")
+	panicIf(percent <= rs.Percent, "can't bump from %d%% to %d%%, must increase", rs.Percent, percent)
+	elapsed := hoursSince(rs.StartedAt)
+	panicIf(elapsed < float64(rs.MinDwellHours), "must wait %d hours since the last change, only %.1f elapsed", rs.MinDwellHours, elapsed)
+
+	if percent >= 100 {
+		promoteRolloutMust(rs)
+		return
+	}
+
+	rs.Percent = percent
+	rs.StartedAt = nowRFC3339()
+	saveRolloutStateMust(rs)
+	uploadRolloutManifestsMust(rs)
+	fmt.Printf("Bumped rollout of %s to %d%%\n", rs.CandidateVer, percent)
+}
+
+// removeRolloutCandidateMust tears down the candidate manifest and rollout
+// policy from all three mirrors plus the local website copy of the next
+// pointer. Shared by promoteRolloutMust and rolloutRollbackMust: whichever
+// way a rollout ends, the candidate state it published must not outlive it
+func removeRolloutCandidateMust() {
+	removeOn := func(mc *minio.Client) {
+		must(mc.Remove("sumatrapdf/update-check-rel-next.txt"))
+		must(mc.Remove("sumatrapdf/rollout.json"))
+	}
+	removeOn(newMinioS3Client())
+	removeOn(newMinioBackblazeClient())
+	removeOn(newMinioSpacesClient())
+
+	nextPath := filepath.Join("website", "update-check-rel-next.txt")
+	err := os.Remove(nextPath)
+	panicIf(err != nil && !os.IsNotExist(err), "%s", err)
+}
+
+// promoteRolloutMust finishes a rollout that reached 100%: ver becomes
+// stable via the normal updateAutoUpdateVer path, and the candidate
+// manifest/rollout.json this rollout published are cleaned up the same
+// way rolloutRollbackMust does, so a finished rollout doesn't leave a
+// stale candidate live on every mirror forever
+func promoteRolloutMust(rs *RolloutPolicy) {
+	ver := rs.CandidateVer
+	err := os.Remove(rolloutStatePath)
+	panicIf(err != nil && !os.IsNotExist(err), "%s", err)
+	updateAutoUpdateVer(ver, 0)
+	removeRolloutCandidateMust()
+	fmt.Printf("Promoted %s to stable, rollout finished\n", ver)
+}
+
+// rolloutRollbackMust restores the prior stable pointer and removes the
+// candidate manifest and rollout policy, on all three mirrors and in the
+// website files real clients actually read
+func rolloutRollbackMust() {
+	rs := loadRolloutStateMust()
+	panicIf(rs == nil, "no rollout in progress")
+
+	stableS := genStableManifestMust(rs.StableVer)
+	uploadStableOn := func(mc *minio.Client) {
+		signAndUploadManifestMust(mc, "sumatrapdf/update-check-rel.txt", stableS)
+	}
+	uploadStableOn(newMinioS3Client())
+	uploadStableOn(newMinioBackblazeClient())
+	uploadStableOn(newMinioSpacesClient())
+
+	key := loadUpdateSignKeyMust()
+	stablePath := filepath.Join("website", "update-check-rel.txt")
+	writeFileMust(stablePath, []byte(addSignatureLine(key, stableS)))
+
+	removeRolloutCandidateMust()
+
+	err := os.Remove(rolloutStatePath)
+	panicIf(err != nil && !os.IsNotExist(err), "%s", err)
+	fmt.Printf("Rolled back candidate %s, restored stable %s\nDon't forget to checkin '%s' and deploy website\n", rs.CandidateVer, rs.StableVer, stablePath)
+}
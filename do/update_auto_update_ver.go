@@ -42,8 +42,20 @@ func validateVer(ver string) {
 	}
 }
 
-func updateAutoUpdateVer(ver string) {
+// updateAutoUpdateVer publishes ver as the new stable release.
+// rolloutPercent of 0 (or 100) ships it to everyone immediately, matching
+// the historical behavior. A value in between 1 and 99 instead starts (or
+// advances) a staged rollout: ver is published as the candidate behind
+// update-check-rel-next.txt and rollout.json, gated to that percentage of
+// clients, while the existing stable manifest is left untouched. Use the
+// `rollout bump`/`rollout rollback` subcommands to progress or cancel it.
+func updateAutoUpdateVer(ver string, rolloutPercent int) {
 	validateVer(ver)
+	if rolloutPercent > 0 && rolloutPercent < 100 {
+		startStagedRollout(ver, rolloutPercent)
+		return
+	}
+
 	// TODO: verify it's bigger than the current version
 	// TODO: add download links
 	s := fmt.Sprintf(`[SumatraPDF]
@@ -54,9 +66,11 @@ Latest %s
 
 	uploadInfo := func(mc *minio.Client) {
 		{
+			// signed: this is the manifest SumatraPDF itself checks at
+			// update-check time, so it gets a Signature: line plus a
+			// detached sumpdf-update.txt.sig uploaded next to it
 			remotePath := "sumatrapdf/sumpdf-update.txt"
-			_, err := mc.UploadData(remotePath, d, true)
-			must(err)
+			signAndUploadManifestMust(mc, remotePath, s)
 		}
 		{
 			remotePath := "sumatrapdf/sumpdf-latest.txt"
@@ -69,8 +83,9 @@ Latest %s
 	uploadInfo(newMinioBackblazeClient())
 	uploadInfo(newMinioSpacesClient())
 
+	key := loadUpdateSignKeyMust()
 	path := filepath.Join("website", "update-check-rel.txt")
-	writeFileMust(path, []byte(s))
+	writeFileMust(path, []byte(addSignatureLine(key, s)))
 
 	fmt.Printf("Don't forget to checkin file '%s' and deploy website\n", path)
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Kept as more files in `do`'s single `main` package rather than a
+// separate `pkg/updatemanifest`, matching update_sign.go: Manifest and
+// friends are built and consumed only by this tool (the uploader and the
+// verify-manifest CLI, both in `do`), so there's no second importer a
+// standalone package would actually serve.
+
+// updateManifestSchemaVersion is bumped whenever a field is removed or
+// its meaning changes; adding a field doesn't require a bump because old
+// clients ignore keys they don't recognize
+const updateManifestSchemaVersion = 1
+
+// Manifest is the structured, JSON equivalent of the legacy *-update.txt
+// files. It's the single source of truth written by the uploader: the INI
+// file is derived from it so the two representations can never drift
+type Manifest struct {
+	SchemaVersion   int        `json:"schemaVersion"`
+	Channel         string     `json:"channel"`
+	Version         string     `json:"version"`
+	PublishedAt     string     `json:"publishedAt"`
+	GitSha          string     `json:"gitSha"`
+	ReleaseNotesURL string     `json:"releaseNotesUrl,omitempty"`
+	Artifacts       []Artifact `json:"artifacts"`
+	// Patches is the JSON equivalent of the Patches: block in *-update.txt;
+	// omitted entirely for a version with nothing to diff against. Extra
+	// field, so it's safe for old clients that don't know about patches yet
+	Patches   []Patch `json:"patches,omitempty"`
+	Signature string  `json:"signature,omitempty"`
+}
+
+// Patch describes a single bsdiff delta that can upgrade fromVersion to
+// Manifest.Version
+type Patch struct {
+	FromVersion string `json:"fromVersion"`
+	URL         string `json:"url"`
+	SHA256      string `json:"sha256"`
+	SizeBytes   int64  `json:"sizeBytes"`
+}
+
+// Artifact describes a single downloadable file belonging to a Manifest
+type Artifact struct {
+	Arch            string `json:"arch"`
+	Kind            string `json:"kind"`
+	URL             string `json:"url"`
+	SHA256          string `json:"sha256"`
+	SizeBytes       int64  `json:"sizeBytes"`
+	MinWindowsBuild string `json:"minWindowsBuild,omitempty"`
+}
+
+const (
+	artifactKindInstaller   = "installer"
+	artifactKindPortableExe = "portable-exe"
+	artifactKindPortableZip = "portable-zip"
+	artifactKindPDB         = "pdb"
+)
+
+// ManifestMarshal serializes m as indented JSON
+func ManifestMarshal(m *Manifest) ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// ManifestParse is the inverse of ManifestMarshal
+func ManifestParse(d []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(d, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ManifestValidate checks that m has the fields a client needs to safely
+// act on it
+func ManifestValidate(m *Manifest) error {
+	if m.SchemaVersion == 0 {
+		return fmt.Errorf("manifest: missing schemaVersion")
+	}
+	if m.Channel == "" {
+		return fmt.Errorf("manifest: missing channel")
+	}
+	if m.Version == "" {
+		return fmt.Errorf("manifest: missing version")
+	}
+	if len(m.Artifacts) == 0 {
+		return fmt.Errorf("manifest: no artifacts")
+	}
+	for _, a := range m.Artifacts {
+		if a.URL == "" || a.SHA256 == "" {
+			return fmt.Errorf("manifest: artifact '%s/%s' missing url or sha256", a.Arch, a.Kind)
+		}
+	}
+	return nil
+}
+
+// hashFileMust reads path once and returns its sha256 and size, so
+// callers that also need the size don't have to stat the file separately
+func hashFileMust(path string) (sha256Hex string, sizeBytes int64) {
+	f, err := os.Open(path)
+	must(err)
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	must(err)
+	return hex.EncodeToString(h.Sum(nil)), n
+}
+
+// buildUpdateManifest assembles the in-memory Manifest for buildType from
+// the artifacts already staged in dirLocal, which is also what was just
+// uploaded via mc.UploadDir, so the sha256/size below always matches
+// what's in storage. portables, if non-nil, supplies the sha256/size that
+// packagePortable already computed while writing the portable zips, so
+// those two artifacts don't get read back off disk and hashed a second time.
+// patches is whatever deltaUpload produced, same as what genUpdateTxt's
+// Patches: block is built from, so the two representations can't drift
+func buildUpdateManifest(buildType string, ver string, dirLocal string, portables map[string]portableArtifact, patches []patchInfo) *Manifest {
+	urls := getDownloadUrlsViaWebsite(buildType, ver)
+	type artifactSpec struct {
+		arch string
+		kind string
+		name string
+		url  string
+	}
+	base := patchArtifactBase(buildType, ver)
+	specs := []artifactSpec{
+		{"64", artifactKindInstaller, base + "-64-install.exe", urls.installer64},
+		{"64", artifactKindPortableExe, base + "-64.exe", urls.portableExe64},
+		{"64", artifactKindPortableZip, base + "-64.zip", urls.portableZip64},
+		{"32", artifactKindInstaller, base + "-install.exe", urls.installer32},
+		{"32", artifactKindPortableExe, base + ".exe", urls.portableExe32},
+		{"32", artifactKindPortableZip, base + ".zip", urls.portableZip32},
+	}
+
+	var artifacts []Artifact
+	for _, spec := range specs {
+		if spec.kind == artifactKindPortableZip {
+			if pa, ok := portables[spec.arch]; ok {
+				artifacts = append(artifacts, Artifact{
+					Arch:      spec.arch,
+					Kind:      spec.kind,
+					URL:       spec.url,
+					SHA256:    pa.sha256Hex,
+					SizeBytes: pa.sizeBytes,
+				})
+				continue
+			}
+		}
+		path := filepath.Join(dirLocal, spec.name)
+		info, err := os.Stat(path)
+		if err != nil {
+			// this variant wasn't produced for this build
+			continue
+		}
+		sha256Hex, size := hashFileMust(path)
+		panicIf(size != info.Size(), "size mismatch for '%s'", path)
+		artifacts = append(artifacts, Artifact{
+			Arch:      spec.arch,
+			Kind:      spec.kind,
+			URL:       spec.url,
+			SHA256:    sha256Hex,
+			SizeBytes: size,
+		})
+	}
+
+	var releaseNotesURL string
+	if buildType == buildTypeRel {
+		releaseNotesURL = "https://www.sumatrapdfreader.org/releasechanges.html"
+	}
+
+	var manifestPatches []Patch
+	for _, p := range patches {
+		manifestPatches = append(manifestPatches, Patch{
+			FromVersion: p.fromVer,
+			URL:         p.url,
+			SHA256:      p.sha256,
+			SizeBytes:   p.size,
+		})
+	}
+
+	return &Manifest{
+		SchemaVersion:   updateManifestSchemaVersion,
+		Channel:         buildType,
+		Version:         ver,
+		PublishedAt:     time.Now().UTC().Format(time.RFC3339),
+		GitSha:          getGitSha1(),
+		ReleaseNotesURL: releaseNotesURL,
+		Artifacts:       artifacts,
+		Patches:         manifestPatches,
+	}
+}
+
+// manifestMarshalMust signs m (over its canonical JSON with an empty
+// Signature field) and returns the final, signed JSON bytes
+func manifestMarshalMust(m *Manifest) []byte {
+	key := loadUpdateSignKeyMust()
+	m.Signature = ""
+	unsigned, err := ManifestMarshal(m)
+	must(err)
+	m.Signature = signBytes(key, unsigned)
+	signed, err := ManifestMarshal(m)
+	must(err)
+	return signed
+}
+
+// verifyManifestCLI re-downloads a *-update.json from the given URL,
+// parses and validates it, and checks every artifact's advertised sha256
+// against a fresh download. Used by the release tool's verify-manifest
+// subcommand
+func verifyManifestCLI(url string) {
+	d := downloadMust(url)
+	m, err := ManifestParse(d)
+	must(err)
+	must(ManifestValidate(m))
+
+	pubKey := loadUpdateVerifyPubKeyMust()
+	sig := m.Signature
+	m.Signature = ""
+	unsigned, err := ManifestMarshal(m)
+	must(err)
+	panicIf(!verifyManifestSignatureBytes(pubKey, unsigned, sig), "signature doesn't verify for manifest at '%s'", url)
+
+	for _, a := range m.Artifacts {
+		data := downloadMust(a.URL)
+		sum := sha256.Sum256(data)
+		got := hex.EncodeToString(sum[:])
+		panicIf(got != a.SHA256, "sha256 mismatch for '%s': manifest says '%s', got '%s'", a.URL, a.SHA256, got)
+		panicIf(int64(len(data)) != a.SizeBytes, "size mismatch for '%s': manifest says %d, got %d", a.URL, a.SizeBytes, len(data))
+	}
+	logf(ctx(), "verify-manifest: '%s' ok, %d artifacts\n", url, len(m.Artifacts))
+}
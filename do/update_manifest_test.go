@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestManifestValidate(t *testing.T) {
+	valid := &Manifest{
+		SchemaVersion: updateManifestSchemaVersion,
+		Channel:       buildTypeRel,
+		Version:       "3.2",
+		Artifacts: []Artifact{
+			{Arch: "64", Kind: artifactKindPortableZip, URL: "https://example.org/a.zip", SHA256: "abc", SizeBytes: 1},
+		},
+	}
+	if err := ManifestValidate(valid); err != nil {
+		t.Errorf("ManifestValidate(valid) = %v, want nil", err)
+	}
+
+	tests := []*Manifest{
+		{Channel: buildTypeRel, Version: "3.2", Artifacts: valid.Artifacts},
+		{SchemaVersion: 1, Version: "3.2", Artifacts: valid.Artifacts},
+		{SchemaVersion: 1, Channel: buildTypeRel, Artifacts: valid.Artifacts},
+		{SchemaVersion: 1, Channel: buildTypeRel, Version: "3.2"},
+		{SchemaVersion: 1, Channel: buildTypeRel, Version: "3.2", Artifacts: []Artifact{{Arch: "64", Kind: artifactKindPortableZip}}},
+	}
+	for i, m := range tests {
+		if err := ManifestValidate(m); err == nil {
+			t.Errorf("ManifestValidate(tests[%d]) = nil, want error", i)
+		}
+	}
+}
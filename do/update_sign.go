@@ -0,0 +1,193 @@
+package main
+
+// Every update manifest we publish (sumpdf-update.txt, release-update.txt,
+// update-check-rel.txt and their pre-release equivalents) is signed with
+// Ed25519 so SumatraPDF can tell a tampered manifest from a genuine one
+// even if TLS/CDN trust is compromised. Signing happens here; a matching
+// public key constant needs to be added to the C++ client separately.
+//
+// `-verify` re-downloads each signed manifest from all three mirrors and
+// checks the signatures agree, so a release fails loudly instead of
+// silently shipping a manifest an out-of-sync mirror can't back up.
+//
+// Kept as more files in `do`'s single `main` package rather than a
+// separate `pkg/updatesign`: every other piece of release tooling here
+// (signing included) is only ever called from `do` itself, not imported
+// elsewhere, so a dedicated package would just add an import path with
+// no second caller.
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/kjk/minio"
+)
+
+// env var pointing at a file containing a base64-encoded Ed25519 private
+// key, used to sign update manifests before they're uploaded to the three
+// mirrors. The matching public key is what gets baked into the client.
+const envUpdateSignKey = "SUMATRA_UPDATE_SIGN_KEY"
+
+// env var pointing at the base64-encoded Ed25519 public key used by
+// --verify to check what's actually sitting in each mirror
+const envUpdateVerifyPubKey = "SUMATRA_UPDATE_VERIFY_PUBKEY"
+
+// signatureLinePrefix is the line embedded inside the manifest text itself
+// as a secondary, offline-verifiable check. It's stripped before computing
+// or verifying the signature so the signature always covers the rest of
+// the manifest, never itself
+const signatureLinePrefix = "Signature: "
+
+func loadUpdateSignKeyMust() ed25519.PrivateKey {
+	path := os.Getenv(envUpdateSignKey)
+	panicIf(path == "", "%s env variable not set", envUpdateSignKey)
+	d, err := os.ReadFile(path)
+	must(err)
+	s := strings.TrimSpace(string(d))
+	keyBytes, err := base64.StdEncoding.DecodeString(s)
+	must(err)
+	panicIf(len(keyBytes) != ed25519.PrivateKeySize, "invalid Ed25519 private key size in '%s'", path)
+	return ed25519.PrivateKey(keyBytes)
+}
+
+func loadUpdateVerifyPubKeyMust() ed25519.PublicKey {
+	s := strings.TrimSpace(os.Getenv(envUpdateVerifyPubKey))
+	panicIf(s == "", "%s env variable not set", envUpdateVerifyPubKey)
+	keyBytes, err := base64.StdEncoding.DecodeString(s)
+	must(err)
+	panicIf(len(keyBytes) != ed25519.PublicKeySize, "invalid Ed25519 public key size in %s", envUpdateVerifyPubKey)
+	return ed25519.PublicKey(keyBytes)
+}
+
+// stripSignatureLine removes an existing "Signature: " line so re-signing
+// or verifying always operates on the same canonical payload
+func stripSignatureLine(s string) string {
+	lines := strings.Split(s, "\n")
+	out := lines[:0:0]
+	for _, line := range lines {
+		if strings.HasPrefix(line, signatureLinePrefix) {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// signBytes signs an arbitrary payload as-is, with no canonicalization.
+// Used for the JSON manifest, which carries its Signature field separately
+// rather than as an embedded text line
+func signBytes(key ed25519.PrivateKey, payload []byte) string {
+	sig := ed25519.Sign(key, payload)
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// signManifest signs the canonical (signature-stripped) form of s and
+// returns the base64-encoded Ed25519 signature, suitable for both the
+// detached "${file}.sig" upload and the embedded Signature: line
+func signManifest(key ed25519.PrivateKey, s string) string {
+	payload := stripSignatureLine(s)
+	sig := ed25519.Sign(key, []byte(payload))
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// addSignatureLine appends a "Signature: " line to s, signed with key
+func addSignatureLine(key ed25519.PrivateKey, s string) string {
+	sig := signManifest(key, s)
+	s = stripSignatureLine(s)
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return s + signatureLinePrefix + sig + "\n"
+}
+
+// verifyManifestSignatureBytes verifies a detached base64 signature (as
+// used for the JSON manifest's Signature field) over an arbitrary payload,
+// as opposed to verifyManifestSignature which extracts an embedded
+// "Signature: " line from INI-style manifest text
+func verifyManifestSignatureBytes(pubKey ed25519.PublicKey, payload []byte, sigB64 string) bool {
+	sigBytes, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pubKey, payload, sigBytes)
+}
+
+func verifyManifestSignature(pubKey ed25519.PublicKey, s string) bool {
+	sig := ""
+	for _, line := range strings.Split(s, "\n") {
+		if strings.HasPrefix(line, signatureLinePrefix) {
+			sig = strings.TrimPrefix(line, signatureLinePrefix)
+			break
+		}
+	}
+	if sig == "" {
+		return false
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pubKey, []byte(stripSignatureLine(s)), sigBytes)
+}
+
+// isSignedUpdateManifestPath reports whether remotePath is one of the
+// update manifests that should be signed and accompanied by a ${file}.sig,
+// as opposed to the *-latest.txt / *.js files that just point at a version
+func isSignedUpdateManifestPath(remotePath string) bool {
+	return strings.HasSuffix(remotePath, "-update.txt")
+}
+
+// signAndUploadManifestMust signs s, uploads the signed manifest to
+// remotePath and a detached "${remotePath}.sig" next to it
+func signAndUploadManifestMust(mc *minio.Client, remotePath string, s string) {
+	key := loadUpdateSignKeyMust()
+	signed := addSignatureLine(key, s)
+	_, err := mc.UploadData(remotePath, []byte(signed), true)
+	must(err)
+	sig := signManifest(key, s)
+	_, err = mc.UploadData(remotePath+".sig", []byte(sig), true)
+	must(err)
+	logf(ctx(), "Uploaded signed manifest '%s' and '%s.sig'\n", remotePath, remotePath)
+}
+
+func downloadMust(url string) []byte {
+	rsp, err := http.Get(url)
+	must(err)
+	defer rsp.Body.Close()
+	panicIf(rsp.StatusCode != http.StatusOK, "GET '%s' failed with '%s'", url, rsp.Status)
+	d, err := io.ReadAll(rsp.Body)
+	must(err)
+	return d
+}
+
+// verifyManifestsMust re-downloads remotePath (and its .sig) from each of
+// the three mirrors and confirms the embedded and detached signatures
+// match a pinned public key and agree with each other. Invoked via the
+// release tool's --verify flag; fails loudly (panics) so a release with an
+// out-of-sync mirror never silently ships
+func verifyManifestsMust(remotePath string) {
+	pubKey := loadUpdateVerifyPubKeyMust()
+	clients := []*minio.Client{newMinioS3Client(), newMinioBackblazeClient(), newMinioSpacesClient()}
+	var firstSig string
+	for _, mc := range clients {
+		url := mc.URLForPath(remotePath)
+		s := string(downloadMust(url))
+		panicIf(!verifyManifestSignature(pubKey, s), "embedded signature doesn't verify for '%s' on %s", remotePath, mc.URLBase())
+
+		sig := strings.TrimSpace(string(downloadMust(url + ".sig")))
+		sigBytes, err := base64.StdEncoding.DecodeString(sig)
+		must(err)
+		panicIf(!ed25519.Verify(pubKey, []byte(stripSignatureLine(s)), sigBytes), "detached .sig doesn't verify for '%s' on %s", remotePath, mc.URLBase())
+
+		if firstSig == "" {
+			firstSig = sig
+		} else {
+			panicIf(sig != firstSig, "signature for '%s' differs between mirrors", remotePath)
+		}
+		logf(ctx(), "verified signature for '%s' on %s\n", remotePath, mc.URLBase())
+	}
+}
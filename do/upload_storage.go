@@ -37,6 +37,7 @@ func getRemotePaths(buildType string) []string {
 			"software/sumatrapdf/sumatralatest.js",
 			"software/sumatrapdf/sumpdf-prerelease-latest.txt",
 			"software/sumatrapdf/sumpdf-prerelease-update.txt",
+			"software/sumatrapdf/sumpdf-prerelease-update.json",
 		}
 	}
 
@@ -45,6 +46,7 @@ func getRemotePaths(buildType string) []string {
 			"software/sumatrapdf/sumadaily.js",
 			"software/sumatrapdf/sumpdf-daily-latest.txt",
 			"software/sumatrapdf/sumpdf-daily-update.txt",
+			"software/sumatrapdf/sumpdf-daily-update.json",
 		}
 	}
 
@@ -53,6 +55,7 @@ func getRemotePaths(buildType string) []string {
 			"software/sumatrapdf/sumarellatest.js",
 			"software/sumatrapdf/release-latest.txt",
 			"software/sumatrapdf/release-update.txt",
+			"software/sumatrapdf/release-update.json",
 		}
 	}
 
@@ -96,6 +99,12 @@ type DownloadUrls struct {
 	installer32   string
 	portableExe32 string
 	portableZip32 string
+
+	// patches lists the bsdiff deltas available to upgrade from an older
+	// version to this one; nil if none were generated (no previous build
+	// was available to diff against). Never set for rel builds: their
+	// patches are only listed in release-update.json
+	patches []patchInfo
 }
 
 func getDownloadUrlsForPrefix(prefix string, buildType string, ver string) *DownloadUrls {
@@ -152,6 +161,14 @@ PortableZip32: ${zip32}
 	rplc("${exe32}", urls.portableExe32)
 	rplc("${zip64}", urls.portableZip64)
 	rplc("${zip32}", urls.portableZip32)
+	// extra keys are ignored by old clients, so this is safe to add
+	// unconditionally once patches become available for this version
+	if len(urls.patches) > 0 {
+		s += "Patches:\n"
+		for _, p := range urls.patches {
+			s += fmt.Sprintf("%s -> %s %s %d\n", p.fromVer, p.url, p.sha256, p.size)
+		}
+	}
 	return s
 }
 
@@ -163,9 +180,17 @@ func testGenUpdateTxt() {
 	os.Exit(0)
 }
 
+// websiteDlPrefix is the mirror-agnostic download prefix that redirects to
+// whichever mirror is serving at update-check time; every URL advertised
+// in a manifest (artifacts and patches alike) needs to go through this
+// same prefix, not a specific mirror's storage URL, so the manifest is
+// byte-identical across mirrors and its signature verifies everywhere
+func websiteDlPrefix(buildType string, ver string) string {
+	return "https://www.sumatrapdfreader.org/dl/" + buildType + "/" + ver + "/"
+}
+
 func getDownloadUrlsViaWebsite(buildType string, ver string) *DownloadUrls {
-	prefix := "https://www.sumatrapdfreader.org/dl/" + buildType + "/" + ver + "/"
-	return getDownloadUrlsForPrefix(prefix, buildType, ver)
+	return getDownloadUrlsForPrefix(websiteDlPrefix(buildType, ver), buildType, ver)
 }
 
 func getDownloadUrlsDirectS3(mc *minio.Client, buildType string, ver string) *DownloadUrls {
@@ -233,7 +258,7 @@ var sumLatestInstaller64 = "{{.Host}}/{{.Prefix}}-64-install.exe";
 	return execTextTemplate(tmplText, d)
 }
 
-func getVersionFilesForLatestInfo(mc *minio.Client, buildType string) [][]string {
+func getVersionFilesForLatestInfo(mc *minio.Client, buildType string, dirLocal string, patches []patchInfo, portables map[string]portableArtifact) [][]string {
 	panicIf(buildType == buildTypeRel)
 	remotePaths := getRemotePaths(buildType)
 	var res [][]string
@@ -256,10 +281,19 @@ func getVersionFilesForLatestInfo(mc *minio.Client, buildType string) [][]string
 		if false {
 			urls = getDownloadUrlsDirectS3(mc, buildType, ver)
 		}
+		urls.patches = patches
 		s := genUpdateTxt(urls, ver)
 		res = append(res, []string{remotePaths[2], s})
 	}
 
+	{
+		// *-update.json : structured equivalent of *-update.txt, driven
+		// from the same in-memory Manifest so the two can never drift
+		m := buildUpdateManifest(buildType, ver, dirLocal, portables, patches)
+		s := string(manifestMarshalMust(m))
+		res = append(res, []string{remotePaths[3], s})
+	}
+
 	return res
 }
 
@@ -275,41 +309,43 @@ func minioVerifyBuildNotInStorageMust(mc *minio.Client, buildType string) {
 }
 
 // https://kjkpubsf.sfo2.digitaloceanspaces.com/software/sumatrapdf/prerel/1024/SumatraPDF-prerelease-install.exe etc.
-func minioUploadBuildMust(mc *minio.Client, buildType string) {
+// portables is whatever packageAllPortableMust already produced for this
+// buildType (nil if flagReproducible is off); it's the same map passed to
+// every mirror's goroutine, since packaging happens once up front
+func minioUploadBuildMust(mc *minio.Client, buildType string, portables map[string]portableArtifact) {
 	timeStart := time.Now()
 	defer func() {
 		logf(ctx(), "Uploaded build '%s' to %s in %s\n", buildType, mc.URLBase(), time.Since(timeStart))
 	}()
 
 	dirRemote := getRemoteDir(buildType)
-	getFinalDirForBuildType := func() string {
-		var dir string
-		switch buildType {
-		case buildTypeRel:
-			dir = "final-rel"
-		case buildTypePreRel:
-			dir = "final-prerel"
-		default:
-			panicIf(true, "invalid buildType '%s'", buildType)
-		}
-		return filepath.Join("out", dir)
-	}
-
-	dirLocal := getFinalDirForBuildType()
+	dirLocal := getFinalDirForBuildType(buildType)
 	//verifyBuildNotInSpaces(c, buildType)
 
 	err := mc.UploadDir(dirRemote, dirLocal, true)
 	must(err)
 
-	// for release build we don't upload files with version info
+	// bsdiff patches are generated for every channel, release included, so
+	// someone a few rel versions behind still gets a small delta instead
+	// of a full re-download
+	patches := deltaUpload(mc, buildType, dirLocal)
+
+	// release builds don't get the versioned *latest.js/*latest.txt/
+	// *update.txt files (those are for the prerel/daily auto-updater); they
+	// do get a *-update.json manifest, published separately below
 	if buildType == buildTypeRel {
+		uploadRelManifestMust(mc, dirLocal, portables, patches)
 		return
 	}
 
 	uploadBuildUpdateInfoMust := func(buildType string) {
-		files := getVersionFilesForLatestInfo(mc, buildType)
+		files := getVersionFilesForLatestInfo(mc, buildType, dirLocal, patches, portables)
 		for _, f := range files {
 			remotePath := f[0]
+			if isSignedUpdateManifestPath(remotePath) {
+				signAndUploadManifestMust(mc, remotePath, f[1])
+				continue
+			}
 			_, err := mc.UploadData(remotePath, []byte(f[1]), true)
 			must(err)
 			logf(ctx(), "Uploaded `%s%s'\n", mc.URLBase(), remotePath)
@@ -319,6 +355,33 @@ func minioUploadBuildMust(mc *minio.Client, buildType string) {
 	uploadBuildUpdateInfoMust(buildType)
 }
 
+// uploadRelManifestMust publishes release-update.json, the one *-update.json
+// variant getVersionFilesForLatestInfo refuses to build (rel doesn't have
+// the other three files it groups it with). This is what verifyArchiveSha256
+// in the version manager, and verify-manifest, check a rel download against
+func uploadRelManifestMust(mc *minio.Client, dirLocal string, portables map[string]portableArtifact, patches []patchInfo) {
+	ver := getVerForBuildType(buildTypeRel)
+	m := buildUpdateManifest(buildTypeRel, ver, dirLocal, portables, patches)
+	d := manifestMarshalMust(m)
+	remotePath := getRemotePaths(buildTypeRel)[3]
+	_, err := mc.UploadData(remotePath, d, true)
+	must(err)
+	logf(ctx(), "Uploaded `%s%s'\n", mc.URLBase(), remotePath)
+}
+
+func getFinalDirForBuildType(buildType string) string {
+	var dir string
+	switch buildType {
+	case buildTypeRel:
+		dir = "final-rel"
+	case buildTypePreRel:
+		dir = "final-prerel"
+	default:
+		panicIf(true, "invalid buildType '%s'", buildType)
+	}
+	return filepath.Join("out", dir)
+}
+
 type filesByVer struct {
 	ver   int
 	files []string
@@ -373,10 +436,12 @@ func minioDeleteOldBuildsPrefix(mc *minio.Client, buildType string) {
 	uri := mc.URLForPath(remoteDir)
 	logf(ctx(), "%d files under '%s'\n", len(keys), uri)
 	byVer := groupFilesByVersion(keys)
+	deletedVers := map[int]bool{}
 	for i, v := range byVer {
 		deleting := (i >= nBuildsToRetain)
 		if deleting {
 			logf(ctx(), "deleting %d\n", v.ver)
+			deletedVers[v.ver] = true
 			if true {
 				for _, key := range v.files {
 					err := mc.Remove(key)
@@ -388,6 +453,30 @@ func minioDeleteOldBuildsPrefix(mc *minio.Client, buildType string) {
 			logf(ctx(), "not deleting %d\n", v.ver)
 		}
 	}
+
+	// a bsdiff patch lives under the directory of the version it upgrades
+	// *to*, so pruning that version's directory above already removes its
+	// patches. What's left orphaned is a patch living in a still-retained
+	// version's directory whose "from" side has just been pruned: nothing
+	// can ever advertise it again via the Patches: block, so delete it too
+	for _, v := range byVer {
+		if deletedVers[v.ver] {
+			continue
+		}
+		for _, key := range v.files {
+			fromVerStr, ok := patchFromVersion(key)
+			if !ok {
+				continue
+			}
+			fromVer, err := strconv.Atoi(fromVerStr)
+			if err != nil || !deletedVers[fromVer] {
+				continue
+			}
+			err = mc.Remove(key)
+			must(err)
+			logf(ctx(), "  deleted orphan patch %s\n", key)
+		}
+	}
 }
 
 func newMinioSpacesClient() *minio.Client {
@@ -436,12 +525,19 @@ func uploadToStorage(opts *BuildOptions, buildType string) {
 	defer func() {
 		logf(ctx(), "uploadToStorage of '%s' finished in %s\n", buildType, time.Since(timeStart))
 	}()
+	// package once, before the mirrors fan out below, so three concurrent
+	// goroutines don't race to zip the same exe into the same zipPath
+	var portables map[string]portableArtifact
+	if flagReproducible {
+		portables = packageAllPortableMust(buildType)
+	}
+
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		mc := newMinioBackblazeClient()
-		minioUploadBuildMust(mc, buildType)
+		minioUploadBuildMust(mc, buildType, portables)
 		minioDeleteOldBuildsPrefix(mc, buildTypePreRel)
 		wg.Done()
 	}()
@@ -449,7 +545,7 @@ func uploadToStorage(opts *BuildOptions, buildType string) {
 	wg.Add(1)
 	go func() {
 		mc := newMinioS3Client()
-		minioUploadBuildMust(mc, buildType)
+		minioUploadBuildMust(mc, buildType, portables)
 		minioDeleteOldBuildsPrefix(mc, buildTypePreRel)
 		wg.Done()
 	}()
@@ -457,12 +553,14 @@ func uploadToStorage(opts *BuildOptions, buildType string) {
 	wg.Add(1)
 	go func() {
 		mc := newMinioSpacesClient()
-		minioUploadBuildMust(mc, buildType)
+		minioUploadBuildMust(mc, buildType, portables)
 		minioDeleteOldBuildsPrefix(mc, buildTypePreRel)
 		wg.Done()
 	}()
 
 	wg.Wait()
+
+	verifyMirrorsConsistent(buildType)
 }
 
 /*
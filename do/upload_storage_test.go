@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenUpdateTxtWithPatches(t *testing.T) {
+	urls := getDownloadUrlsViaWebsite(buildTypePreRel, "14283")
+	s := genUpdateTxt(urls, "14283")
+	if strings.Contains(s, "Patches:") {
+		t.Errorf("genUpdateTxt without patches shouldn't contain a Patches: block, got:\n%s", s)
+	}
+
+	urls.patches = []patchInfo{
+		{fromVer: "14280", url: "https://example.org/a.bsdiff", sha256: "abc", size: 123},
+	}
+	s = genUpdateTxt(urls, "14283")
+	if !strings.Contains(s, "Patches:") {
+		t.Errorf("genUpdateTxt with patches should contain a Patches: block, got:\n%s", s)
+	}
+	want := "14280 -> https://example.org/a.bsdiff abc 123\n"
+	if !strings.Contains(s, want) {
+		t.Errorf("genUpdateTxt missing patch line %q, got:\n%s", want, s)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kjk/minio"
+)
+
+// Local version manager for the rel/prerel builds we publish, in the
+// spirit of nvm/j for language runtimes: `install <ver>` fetches and
+// unpacks a build under %LOCALAPPDATA%\SumatraPDF\versions\<ver>, `use
+// <ver>` points a `current` link at it, and `ls`/`ls-remote`/`uninstall`/
+// `which` round out basic bookkeeping. Useful for reproducing a bug
+// report against the exact historical build it came from, without
+// hand-crafting S3 URLs.
+
+func versionManagerBaseDir() string {
+	return filepath.Join(os.Getenv("LOCALAPPDATA"), "SumatraPDF", "versions")
+}
+
+func versionManagerDownloadsDir() string {
+	return filepath.Join(versionManagerBaseDir(), "downloads")
+}
+
+func versionManagerDir(ver string) string {
+	return filepath.Join(versionManagerBaseDir(), ver)
+}
+
+func versionManagerCurrentLink() string {
+	return filepath.Join(versionManagerBaseDir(), "current")
+}
+
+func dirExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// versionManagerLs lists versions already installed locally, most recent
+// first
+func versionManagerLs() []string {
+	entries, err := os.ReadDir(versionManagerBaseDir())
+	if err != nil {
+		return nil
+	}
+	var vers []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || name == "downloads" || name == "current" {
+			continue
+		}
+		vers = append(vers, name)
+	}
+	sort.Slice(vers, func(i, j int) bool {
+		return compareVersions(vers[i], vers[j]) > 0
+	})
+	return vers
+}
+
+// listRemoteVersions parses the same S3 listing groupFilesByVersion
+// understands, but works for both the numeric prerel build numbers and
+// the dotted rel versions
+func listRemoteVersions(mc *minio.Client, buildType string) []string {
+	remoteDir := "software/sumatrapdf/" + buildType + "/"
+	seen := map[string]bool{}
+	var vers []string
+	for f := range mc.ListObjects(remoteDir) {
+		parts := strings.Split(f.Key, "/")
+		if len(parts) < 4 {
+			continue
+		}
+		v := parts[3]
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		vers = append(vers, v)
+	}
+	sort.Slice(vers, func(i, j int) bool {
+		return compareVersions(vers[i], vers[j]) > 0
+	})
+	return vers
+}
+
+// versionManagerLsRemote returns the versions available in storage,
+// keyed by build type ("rel", "prerel")
+func versionManagerLsRemote() map[string][]string {
+	mc := newMinioS3Client()
+	return map[string][]string{
+		buildTypeRel:    listRemoteVersions(mc, buildTypeRel),
+		buildTypePreRel: listRemoteVersions(mc, buildTypePreRel),
+	}
+}
+
+// verifyArchiveSha256 checks archivePath against the live *-update.json
+// manifest for buildType. Only the currently-published version still has
+// a retained manifest (older manifests get overwritten on every release),
+// so this is a best-effort check: it logs and returns for anything older
+func verifyArchiveSha256(mc *minio.Client, buildType string, ver string, arch string, kind string, archivePath string) {
+	remotePaths := getRemotePaths(buildType)
+	d := downloadMust(mc.URLForPath(remotePaths[3]))
+	m, err := ManifestParse(d)
+	must(err)
+	if ver != m.Version {
+		logf(ctx(), "no retained manifest for older version '%s', skipping sha256 verification\n", ver)
+		return
+	}
+	for _, a := range m.Artifacts {
+		if a.Arch == arch && a.Kind == kind {
+			got, _ := hashFileMust(archivePath)
+			panicIf(got != a.SHA256, "sha256 mismatch for '%s': manifest says '%s', got '%s'", archivePath, a.SHA256, got)
+			logf(ctx(), "sha256 verified for '%s'\n", archivePath)
+			return
+		}
+	}
+	logf(ctx(), "no '%s/%s' artifact in manifest, skipping sha256 verification\n", arch, kind)
+}
+
+// unzipTo extracts archivePath into destDir, rejecting any entry that
+// would escape destDir
+func unzipTo(archivePath string, destDir string) error {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		path := filepath.Join(destDir, f.Name)
+		if !strings.HasPrefix(path, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("unzipTo: '%s' escapes destination dir", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, destPath string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+// versionManagerInstallMust downloads (or reuses a cached copy of) ver
+// and, for the portable variant, unpacks it under
+// versions/<ver>/. For the installer variant it just caches the .exe;
+// silently running an installer isn't something this tool does on the
+// user's behalf
+func versionManagerInstallMust(buildType string, ver string, arch string, wantInstaller bool) {
+	must(os.MkdirAll(versionManagerDownloadsDir(), 0o755))
+	mc := newMinioS3Client()
+	prefix := mc.URLBase() + "software/sumatrapdf/" + buildType + "/" + ver + "/"
+	urls := getDownloadUrlsForPrefix(prefix, buildType, ver)
+
+	var url, name, kind string
+	switch {
+	case wantInstaller && arch == "64":
+		url, name, kind = urls.installer64, patchArtifactBase(buildType, ver)+"-64-install.exe", artifactKindInstaller
+	case wantInstaller:
+		url, name, kind = urls.installer32, patchArtifactBase(buildType, ver)+"-install.exe", artifactKindInstaller
+	case arch == "64":
+		url, name, kind = urls.portableZip64, patchArtifactBase(buildType, ver)+"-64.zip", artifactKindPortableZip
+	default:
+		url, name, kind = urls.portableZip32, patchArtifactBase(buildType, ver)+".zip", artifactKindPortableZip
+	}
+
+	archivePath := filepath.Join(versionManagerDownloadsDir(), name)
+	if _, err := os.Stat(archivePath); err != nil {
+		logf(ctx(), "downloading '%s'\n", url)
+		d := downloadMust(url)
+		must(os.WriteFile(archivePath, d, 0o644))
+	} else {
+		logf(ctx(), "using cached '%s'\n", archivePath)
+	}
+
+	verifyArchiveSha256(mc, buildType, ver, arch, kind, archivePath)
+
+	destDir := versionManagerDir(ver)
+	if wantInstaller {
+		logf(ctx(), "cached installer at '%s'; run it to install %s\n", archivePath, ver)
+		return
+	}
+	must(os.MkdirAll(destDir, 0o755))
+	must(unzipTo(archivePath, destDir))
+	logf(ctx(), "installed %s into '%s'\n", ver, destDir)
+}
+
+// versionManagerUseMust points the `current` link at an already-installed
+// version. On Windows this needs developer mode or admin rights to create
+// a symlink; adding the link to PATH is left to the user / shell profile
+func versionManagerUseMust(ver string) {
+	destDir := versionManagerDir(ver)
+	panicIf(!dirExists(destDir), "version '%s' isn't installed, run install first", ver)
+	link := versionManagerCurrentLink()
+	_ = os.Remove(link)
+	must(os.Symlink(destDir, link))
+	fmt.Printf("Now using %s (%s)\nMake sure '%s' is on PATH\n", ver, destDir, link)
+}
+
+func versionManagerUninstallMust(ver string) {
+	destDir := versionManagerDir(ver)
+	panicIf(!dirExists(destDir), "version '%s' isn't installed", ver)
+	must(os.RemoveAll(destDir))
+	fmt.Printf("Uninstalled %s\n", ver)
+}
+
+// versionManagerWhich returns the directory `current` points at, or ""
+// if `use` hasn't been run yet
+func versionManagerWhich() string {
+	target, err := os.Readlink(versionManagerCurrentLink())
+	if err != nil {
+		return ""
+	}
+	return target
+}